@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// feedsFile is the on-disk shape of the feeds config (feeds.yaml by default).
+type feedsFile struct {
+	Feeds []feedConfigEntry `yaml:"feeds"`
+}
+
+// feedConfigEntry describes one geofeed provider in the config file. It's
+// the YAML-facing twin of GeoFeed, which keeps its fields unexported.
+type feedConfigEntry struct {
+	Key    string `yaml:"key"`
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url"`
+	Color  string `yaml:"color"`
+	Mapbox struct {
+		Center []float64 `yaml:"center"`
+		Zoom   int       `yaml:"zoom"`
+	} `yaml:"mapbox"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// loadFeeds reads the feed registry from path (YAML or JSON, both decode
+// with the same yaml.v3 unmarshaller), and applies the --only filter when
+// onlyKeys is non-empty.
+func loadFeeds(path string, onlyKeys []string) ([]GeoFeed, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading feeds config %s: %w", path, err)
+	}
+	var ff feedsFile
+	if err := yaml.Unmarshal(blob, &ff); err != nil {
+		return nil, fmt.Errorf("parsing feeds config %s: %w", path, err)
+	}
+	var only map[string]bool
+	if len(onlyKeys) > 0 {
+		only = make(map[string]bool, len(onlyKeys))
+		for _, k := range onlyKeys {
+			only[strings.ToLower(strings.TrimSpace(k))] = true
+		}
+	}
+	feeds := make([]GeoFeed, 0, len(ff.Feeds))
+	for _, e := range ff.Feeds {
+		if only != nil && !only[strings.ToLower(e.Key)] {
+			continue
+		}
+		feed := GeoFeed{
+			key:          e.Key,
+			providerName: e.Name,
+			url:          e.URL,
+			brandColor:   e.Color,
+			authHeaders:  e.Headers,
+		}
+		feed.mapbox.centerLngLat = e.Mapbox.Center
+		feed.mapbox.defaultZoom = e.Mapbox.Zoom
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
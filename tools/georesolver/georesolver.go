@@ -0,0 +1,169 @@
+// Package georesolver abstracts reverse IP geolocation so the generator can
+// run against the hosted Fastah API or against local MaxMind GeoLite2
+// databases without any other code in tools/ caring which one is in use.
+package georesolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoRecord is the location (and, where the backend can supply it, network)
+// information resolved for a single sample IP address.
+type GeoRecord struct {
+	CountryCode string
+	CountryName string
+	StateCode   string
+	StateName   string
+	CityName    string
+	Lat         float64
+	Lng         float64
+	// ASN and ASNOrg are only populated when the resolver has an ASN
+	// database loaded; callers should fall back to the original RFC8805
+	// prefix for a weaker notion of network identity otherwise.
+	ASN    uint
+	ASNOrg string
+}
+
+// GeoResolver maps a sample IP address to a GeoRecord. Implementations may
+// hold open file handles or network clients, so callers must Close() them
+// once the generator run is finished.
+type GeoResolver interface {
+	Resolve(ip netip.Addr) (GeoRecord, error)
+	Close() error
+}
+
+// fastahResponse mirrors the subset of the hosted Fastah IP Geolocation API
+// response that we map into a GeoRecord.
+type fastahResponse struct {
+	UserGeo struct {
+		CountryName string  `json:"countryName"`
+		CountryCode string  `json:"countryCode"`
+		StateName   string  `json:"stateName"`
+		StateCode   string  `json:"stateCode"`
+		CityName    string  `json:"cityName"`
+		Lat         float64 `json:"lat"`
+		Lng         float64 `json:"lng"`
+	} `json:"userGeo"`
+}
+
+// FastahResolver resolves IPs via the hosted Fastah IP Geolocation API at
+// space.us-east-1.aws.api.getfastah.com. This is
+// the original behavior of the generator, and it requires FASTAH_PRIVATE_API_KEY
+// to be set and network access to the API.
+type FastahResolver struct {
+	apiKey string
+	client *http.Client
+}
+
+// NewFastahResolver builds a resolver that calls the hosted Fastah API with
+// the given private API key.
+func NewFastahResolver(apiKey string) *FastahResolver {
+	return &FastahResolver{
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *FastahResolver) Resolve(ip netip.Addr) (GeoRecord, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://space.us-east-1.aws.api.getfastah.com/ip/%s", ip.String()), nil)
+	if err != nil {
+		return GeoRecord{}, fmt.Errorf("preparing request for Fastah IP Geolocation API: %w", err)
+	}
+	req.Header.Set("Fastah-Key", r.apiKey)
+	req.Header.Set("x-api-key", r.apiKey)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return GeoRecord{}, fmt.Errorf("calling Fastah IP Geolocation API for IP %s: %w", ip.String(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return GeoRecord{}, fmt.Errorf("Fastah IP Geolocation API returned HTTP %d for IP %s", resp.StatusCode, ip.String())
+	}
+	var fr fastahResponse
+	if err := json.NewDecoder(resp.Body).Decode(&fr); err != nil {
+		return GeoRecord{}, fmt.Errorf("parsing Fastah IP Geolocation API response for IP %s: %w", ip.String(), err)
+	}
+	return GeoRecord{
+		CountryCode: fr.UserGeo.CountryCode,
+		CountryName: fr.UserGeo.CountryName,
+		StateCode:   fr.UserGeo.StateCode,
+		StateName:   fr.UserGeo.StateName,
+		CityName:    fr.UserGeo.CityName,
+		Lat:         fr.UserGeo.Lat,
+		Lng:         fr.UserGeo.Lng,
+	}, nil
+}
+
+func (r *FastahResolver) Close() error { return nil }
+
+// MaxMindResolver resolves IPs against locally-held GeoLite2 mmdb files, so
+// the generator can run without API credentials or network access.
+type MaxMindResolver struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader // optional, nil when no ASN db was supplied
+}
+
+// NewMaxMindResolver opens the GeoLite2-City database at cityDBPath, and
+// optionally the GeoLite2-ASN database at asnDBPath (pass "" to skip ASN
+// enrichment). The caller must Close() the returned resolver when done.
+func NewMaxMindResolver(cityDBPath, asnDBPath string) (*MaxMindResolver, error) {
+	city, err := geoip2.Open(cityDBPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening MaxMind City mmdb %s: %w", cityDBPath, err)
+	}
+	r := &MaxMindResolver{city: city}
+	if asnDBPath != "" {
+		asn, err := geoip2.Open(asnDBPath)
+		if err != nil {
+			city.Close()
+			return nil, fmt.Errorf("opening MaxMind ASN mmdb %s: %w", asnDBPath, err)
+		}
+		r.asn = asn
+	}
+	return r, nil
+}
+
+func (r *MaxMindResolver) Resolve(ip netip.Addr) (GeoRecord, error) {
+	rec, err := r.city.City(net.IP(ip.AsSlice()))
+	if err != nil {
+		return GeoRecord{}, fmt.Errorf("MaxMind City lookup for IP %s: %w", ip.String(), err)
+	}
+	var stateCode, stateName string
+	if len(rec.Subdivisions) > 0 {
+		stateCode = rec.Subdivisions[0].IsoCode
+		stateName = rec.Subdivisions[0].Names["en"]
+	}
+	gr := GeoRecord{
+		CountryCode: rec.Country.IsoCode,
+		CountryName: rec.Country.Names["en"],
+		StateCode:   stateCode,
+		StateName:   stateName,
+		CityName:    rec.City.Names["en"],
+		Lat:         rec.Location.Latitude,
+		Lng:         rec.Location.Longitude,
+	}
+	if r.asn != nil {
+		asnRec, err := r.asn.ASN(net.IP(ip.AsSlice()))
+		if err != nil {
+			fmt.Printf("MaxMind ASN lookup for IP %s: %s\n", ip.String(), err)
+		} else {
+			gr.ASN = asnRec.AutonomousSystemNumber
+			gr.ASNOrg = asnRec.AutonomousSystemOrganization
+		}
+	}
+	return gr, nil
+}
+
+func (r *MaxMindResolver) Close() error {
+	if r.asn != nil {
+		r.asn.Close()
+	}
+	return r.city.Close()
+}
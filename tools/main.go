@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"net/netip"
@@ -14,13 +15,19 @@ import (
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 	"go4.org/netipx"
+
+	"fastah/space/tools/georesolver"
+	"fastah/space/tools/mapimage"
+	"fastah/space/tools/satprefix"
 )
 
 // GeoFeed are properties of a IP geolocation feed - usually in RFC8805 format
 type GeoFeed struct {
-	key          string // Unique key for the feed, used to generate directory and filenames on disk
-	providerName string // Display name of the provider
-	url          string // url to slurp it from
+	key          string            // Unique key for the feed, used to generate directory and filenames on disk
+	providerName string            // Display name of the provider
+	url          string            // url to slurp it from
+	brandColor   string            // marker-color for this provider's map pins, falls back to colorForBrand(key)
+	authHeaders  map[string]string // optional headers (e.g. auth tokens) to send when fetching url
 	mapbox       struct {
 		centerLngLat []float64
 		defaultZoom  int
@@ -33,8 +40,28 @@ type IP struct {
 	CC string     `json:"cciso2"`
 }
 
-func readCSVUrl(key, url string) ([][]string, *time.Time, error) {
-	resp, err := http.Get(url)
+// sampleLocation is the representative IP address picked for a (cc, region,
+// city) tuple, along with the RFC8805 prefix it was drawn from and the
+// country code that prefix's CSV row declared. The prefix is kept around so
+// it can be surfaced as the feature's "network" even when the geo resolver
+// in use has no ASN database of its own; the declared cc is kept because the
+// resolver may report a different country for the sample IP than the feed
+// itself declared (e.g. near a border, or MaxMind vs. self-reported RFC8805).
+type sampleLocation struct {
+	ip         netip.Addr
+	prefix     netip.Prefix
+	declaredCC string
+}
+
+func readCSVUrl(key, url string, headers map[string]string) ([][]string, *time.Time, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -54,55 +81,150 @@ func readCSVUrl(key, url string) ([][]string, *time.Time, error) {
 	return data, &lmt, nil
 }
 
+var (
+	resolverFlag  = flag.String("resolver", "fastah", "geolocation backend to use: fastah or maxmind")
+	maxmindCityDB = flag.String("maxmind-city-db", "", "path to a GeoLite2-City.mmdb file (required for --resolver=maxmind)")
+	maxmindASNDB  = flag.String("maxmind-asn-db", "", "path to a GeoLite2-ASN.mmdb file, for asn/asnOrg enrichment (optional, --resolver=maxmind only)")
+	feedsPath     = flag.String("feeds", "feeds.yaml", "path to the feed registry config file")
+	onlyFlag      = flag.String("only", "", "comma-separated list of feed keys to generate (default: all feeds in the config)")
+)
+
+// newResolver builds the GeoResolver selected by --resolver, falling back to
+// the Fastah backend (with a warning) if the requested one can't be used.
+func newResolver() georesolver.GeoResolver {
+	switch *resolverFlag {
+	case "maxmind":
+		if *maxmindCityDB == "" {
+			fmt.Println("--resolver=maxmind requires --maxmind-city-db, falling back to fastah")
+			break
+		}
+		r, err := georesolver.NewMaxMindResolver(*maxmindCityDB, *maxmindASNDB)
+		if err != nil {
+			fmt.Printf("Error opening MaxMind resolver, falling back to fastah: %s\n", err)
+			break
+		}
+		return r
+	case "fastah":
+		// nothing to do here; the Fastah resolver is returned after the switch
+	default:
+		fmt.Printf("Unknown --resolver=%s, falling back to fastah\n", *resolverFlag)
+	}
+	return georesolver.NewFastahResolver(os.Getenv("FASTAH_PRIVATE_API_KEY"))
+}
+
 func main() {
-	feeds := []GeoFeed{
-		{key: "starlink", providerName: "SpaceX Starlink", url: "https://geoip.starlinkisp.net/feed.csv"},
-		{key: "viasat", providerName: "Viasat", url: "https://raw.githubusercontent.com/Viasat/geofeed/main/geofeed.csv"},
+	// `serve` is the only subcommand; anything else (including no args at
+	// all) runs the generator, which was the tool's only mode historically.
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+	flag.Parse()
+	runGenerate()
+}
+
+func runGenerate() {
+	resolver := newResolver()
+	defer resolver.Close()
+
+	var only []string
+	if *onlyFlag != "" {
+		only = strings.Split(*onlyFlag, ",")
+	}
+	feeds, err := loadFeeds(*feedsPath, only)
+	if err != nil {
+		fmt.Printf("Error loading feeds config: %s\n", err)
+		os.Exit(1)
 	}
 
 	for _, feed := range feeds {
-		var locations = make(map[string]netip.Addr) // Country Code ISO2, State or Province ISO2, City
+		var locations = make(map[string]sampleLocation) // Country Code ISO2, State or Province ISO2, City
 
 		fmt.Printf("[%s] %s\n", feed.key, feed.url)
 		var rows [][]string
 		var lmt *time.Time
 		var err error
-		if rows, lmt, err = readCSVUrl(feed.key, feed.url); err != nil {
+		if rows, lmt, err = readCSVUrl(feed.key, feed.url, feed.authHeaders); err != nil {
 			fmt.Printf("[%s] Error reading CSV: %s\n", feed.key, err)
 			continue
 		}
+		// Prepare directory heirarchy to write JSON files to disk
+		dirpath := filepath.Join("..", "gen", "latest-feeds", strings.ToLower(feed.key))
+		err = os.MkdirAll(dirpath, 0755)
+		if err != nil {
+			fmt.Printf("[%s] Error mkdir generated files dir: %s\n", feed.key, err)
+			continue
+		}
+
+		// Validate, then merge adjacent/overlapping prefixes per location.
+		validRows, validationReport := validateRows(feed.key, rows)
+		fmt.Printf("[%s] Validated %d/%d rows, %d rejected\n", feed.key, len(validRows), validationReport.TotalRows, len(validationReport.Issues))
+		if err := writeJSONFile(filepath.Join(dirpath, "validation.json"), validationReport); err != nil {
+			fmt.Printf("[%s] Error writing validation report: %s\n", feed.key, err)
+		}
+		merged, ccByLocation := aggregateByLocation(validRows)
+
 		// Extract valid subnets from the CSV
 		sampleIps := make(map[string][]netip.Addr)
 		visibleCountries := make(map[string]bool)
-		for _, row := range rows {
-			if prefix, err := netip.ParsePrefix(strings.TrimSpace(row[0])); err != nil {
-				fmt.Printf("[%s] Error parsing prefix %s: %s\n", feed.key, row[0], err)
-			} else {
-				locationKey := feedColumnsToKey(row)
-				fmt.Printf("[%s] location key = %s\n", feed.key, locationKey)
+		currentSnapshot := make(map[string]string)
+		for locationKey, prefixes := range merged {
+			cc := ccByLocation[locationKey]
+			visibleCountries[cc] = true
+			for _, prefix := range prefixes {
+				currentSnapshot[prefix.String()] = locationKey
 				// Add a single representative IP address from each subnet to a list of samples.
-				// Keep that row's country code/ISO2 too, as it makes the HTML UI more fun.
-				if prefix.IsValid() && !prefix.Addr().IsPrivate() && locationKey != "" {
-					ip := prefix.Addr()
-					// For subnets which aren't single IP address (v4 /32 or v6 /128), we add one IP address to start to get better aesthetics
-					if !prefix.IsSingleIP() {
-						r := netipx.RangeOfPrefix(prefix)
-						ip = r.From().Next()
-					}
-					cc := strings.ToUpper(strings.TrimSpace(row[1]))
-					visibleCountries[cc] = true
-					sampleIps[cc] = append(sampleIps[cc], ip)
-					locations[locationKey] = ip // this clobbers any older value, but that's fine as we only want one representative IP per country-state-city tuple
+				ip := prefix.Addr()
+				// For subnets which aren't single IP address (v4 /32 or v6 /128), we add one IP address to start to get better aesthetics
+				if !prefix.IsSingleIP() {
+					r := netipx.RangeOfPrefix(prefix)
+					ip = r.From().Next()
 				}
+				sampleIps[cc] = append(sampleIps[cc], ip)
+				locations[locationKey] = sampleLocation{ip: ip, prefix: prefix, declaredCC: cc} // this clobbers any older value, but that's fine as we only want one representative IP per country-state-city tuple
 			}
 		}
 		fmt.Printf("[%s] Read %d valid subnets from %d rows of the RFC8805 CSV\n", feed.key, len(sampleIps), len(rows))
-		// Prepare directory heirarchy to write JSON files to disk
-		dirpath := filepath.Join("..", "gen", "latest-feeds", strings.ToLower(feed.key))
-		err = os.MkdirAll(dirpath, 0755)
+
+		// Diff against the previous run's snapshot so rollouts show up as a changelog.
+		snapshotPath := filepath.Join(dirpath, "snapshot.json")
+		previousSnapshot, err := loadSnapshot(snapshotPath)
 		if err != nil {
-			fmt.Printf("[%s] Error mkdir generated files dir: %s\n", feed.key, err)
-			continue
+			fmt.Printf("[%s] Error loading previous snapshot, treating as empty: %s\n", feed.key, err)
+			previousSnapshot = map[string]string{}
+		}
+		diff := diffSnapshots(feed.key, previousSnapshot, currentSnapshot)
+		fmt.Printf("[%s] Diff vs previous run: %d added, %d removed, %d moved\n", feed.key, len(diff.Added), len(diff.Removed), len(diff.Moved))
+		if err := writeJSONFile(filepath.Join(dirpath, "diff.json"), diff); err != nil {
+			fmt.Printf("[%s] Error writing diff report: %s\n", feed.key, err)
+		}
+		if err := writeJSONFile(snapshotPath, currentSnapshot); err != nil {
+			fmt.Printf("[%s] Error writing snapshot: %s\n", feed.key, err)
+		}
+
+		// Build and ship a compiled longest-prefix-match index : gen/latest-feeds/<feed-key>/index.bin
+		index := satprefix.NewSet()
+		for locationKey, prefixes := range merged {
+			parts := strings.SplitN(locationKey, ",", 3)
+			var cc, region, city string
+			if len(parts) > 0 {
+				cc = parts[0]
+			}
+			if len(parts) > 1 {
+				region = parts[1]
+			}
+			if len(parts) > 2 {
+				city = parts[2]
+			}
+			for _, prefix := range prefixes {
+				index.Insert(satprefix.Entry{Provider: feed.key, CC: cc, Region: region, City: city, Prefix: prefix})
+			}
+		}
+		indexBlob, err := index.MarshalBinary()
+		if err != nil {
+			fmt.Printf("[%s] Error marshaling prefix index: %s\n", feed.key, err)
+		} else if err := os.WriteFile(filepath.Join(dirpath, "index.bin"), indexBlob, 0644); err != nil {
+			fmt.Printf("[%s] Error writing prefix index: %s\n", feed.key, err)
 		}
 
 		// Write metadata JSON about the root data source : gen/latest-feeds/<feed-key>/rfc8805.meta.json
@@ -134,7 +256,7 @@ func main() {
 		}
 
 		// Write JSON file with IP samples and their locations : gen/latest-feeds/<feed-key>/samples.json
-		fc := ipToGeoJson(feed.key, feed.providerName, locations)
+		fc := ipToGeoJson(resolver, feed.key, feed.providerName, feed.brandColor, locations)
 		gj, _ := json.MarshalIndent(fc, "", " ")
 		//fmt.Println(string(gj))
 		err = os.WriteFile(filepath.Join(dirpath, "samples.json"), gj, 0644)
@@ -142,13 +264,33 @@ func main() {
 			fmt.Printf("[%s] Error writing generated JSON IP samples file: %s\n", feed.key, err)
 			continue
 		}
-		// generate map image for a prettier UI
-		//for i, cc := range vcl {
-		//	vcl[i] = countries.ByName(cc).String()
-		//}
-		//fmt.Printf("[%s] Generating map image for: %s\n", feed.key, vcl)
-		//buildMapImage(vcl, filepath.Join(dirpath, "all-countries.png"), feed.key)
-
+		// Render map images for a prettier UI, without depending on a hosted tile service.
+		brandColor := feed.brandColor
+		if brandColor == "" {
+			brandColor = colorForBrand(feed.key)
+		}
+		markers := make([]mapimage.Marker, 0, len(fc.Features))
+		for _, f := range fc.Features {
+			if pt, ok := f.Geometry.(orb.Point); ok {
+				markers = append(markers, mapimage.Marker{Lng: pt[0], Lat: pt[1], Color: brandColor})
+			}
+		}
+		if err := mapimage.Render(visibleCountries, brandColor, markers, filepath.Join(dirpath, "all-countries.png")); err != nil {
+			fmt.Printf("[%s] Error rendering all-countries map image: %s\n", feed.key, err)
+		}
+		for _, cc := range vcl {
+			var ccMarkers []mapimage.Marker
+			for _, f := range fc.Features {
+				if f.Properties["rfc8805Cc"] == cc {
+					if pt, ok := f.Geometry.(orb.Point); ok {
+						ccMarkers = append(ccMarkers, mapimage.Marker{Lng: pt[0], Lat: pt[1], Color: brandColor})
+					}
+				}
+			}
+			if err := mapimage.Render(map[string]bool{cc: true}, brandColor, ccMarkers, filepath.Join(dirpath, cc+".png")); err != nil {
+				fmt.Printf("[%s] Error rendering map image for %s: %s\n", feed.key, cc, err)
+			}
+		}
 	}
 }
 
@@ -175,104 +317,59 @@ func feedColumnsToKey(cols []string) string {
 	return strings.Join([]string{cc, st, city}, ",")
 }
 
-type fastahResponse struct {
-	IP          string `json:"ip"`
-	IsSatellite bool   `json:"isSatellite"`
-	UserGeo     struct {
-		CountryName    string  `json:"countryName"`
-		CountryCode    string  `json:"countryCode"`
-		StateName      string  `json:"stateName"`
-		StateCode      string  `json:"stateCode"`
-		CityName       string  `json:"cityName"`
-		Lat            float64 `json:"lat"`
-		Lng            float64 `json:"lng"`
-		AccuracyRadius int     `json:"accuracyRadius"`
-		Tz             string  `json:"tz"`
-		CityGeonamesID int     `json:"cityGeonamesId"`
-		ContinentCode  string  `json:"continentCode"`
-	} `json:"userGeo"`
-	Satellite struct {
-		Provider string `json:"provider"`
-	} `json:"satellite"`
-	OnAws struct {
-		Filter  []string `json:"filter"`
-		Nearest []struct {
-			Name      string `json:"name"`
-			Svc       string `json:"svc"`
-			ID        string `json:"id"`
-			SimpleRtt int    `json:"simpleRtt"`
-		} `json:"nearest"`
-	} `json:"onAws"`
-	OnAzure struct {
-		Filter  []string `json:"filter"`
-		Nearest []struct {
-			Name      string `json:"name"`
-			Svc       string `json:"svc"`
-			ID        string `json:"id"`
-			SimpleRtt int    `json:"simpleRtt"`
-		} `json:"nearest"`
-	} `json:"onAzure"`
-	ExpiresAt struct {
-		Epoch int64     `json:"epoch"`
-		Time  time.Time `json:"time"`
-	} `json:"expiresAt"`
-}
-
-// ipToGeoJson makes API calls to the remote Fastah service and maps IP addresses to locations inside a GeoJSON fit for rendering on a map
-func ipToGeoJson(key string, providerLabel string, locations map[string]netip.Addr) *geojson.FeatureCollection {
-	// Convert the map of sample IP addresses to a map of reverse-geocoded locations
-	fastahKey := os.Getenv("FASTAH_PRIVATE_API_KEY") // Not for use with browser-side requests
+// ipToGeoJson resolves each sample IP address's location via the given
+// GeoResolver and maps the results into a GeoJSON FeatureCollection fit for
+// rendering on a map.
+func ipToGeoJson(resolver georesolver.GeoResolver, key string, providerLabel string, brandColor string, locations map[string]sampleLocation) *geojson.FeatureCollection {
 	fc := geojson.NewFeatureCollection()
-	var c = &http.Client{Timeout: 5 * time.Second}
-	for uniqueloc, ip := range locations {
+	for uniqueloc, loc := range locations {
+		ip := loc.ip
 		fmt.Printf("[%s] Processing loc %s\n", key, uniqueloc)
-		var req *http.Request
-		var resp *http.Response
-		var err error
-		// Fastah lookup to provide a lat/long for the IP address
-		req, err = http.NewRequest("GET", fmt.Sprintf("https://space.us-east-1.aws.api.getfastah.com/ip/%s", ip.String()), nil)
-		if err != nil {
-			fmt.Printf("[%s] Error preparing request for Fastah IP Geolocation API: %v\n", key, err)
-			continue
-		}
-		req.Header.Set("Fastah-Key", fastahKey)
-		req.Header.Set("x-api-key", fastahKey)
-		resp, err = c.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			fmt.Printf("[%s] Error calling Fastah IP Geolocation API for IP %s: %v (http code = %d)\n", key, ip.String(), err, resp.StatusCode)
-			panic("API call error")
-		}
-		defer resp.Body.Close()
-		var fr fastahResponse
-		err = json.NewDecoder(resp.Body).Decode(&fr)
+		gr, err := resolver.Resolve(ip)
 		if err != nil {
-			fmt.Printf("[%s] Error parsing Fastah IP Geolocation API response IP %s: %v\n", key, ip.String(), err)
+			fmt.Printf("[%s] Error resolving IP %s: %v\n", key, ip.String(), err)
 			continue
 		}
-		fmt.Printf("[%s] Fastah IP Geolocation API reports RFC8805 entry %s/%s maps to %+v\n", key, ip.String(), uniqueloc, fr)
-		f := geojson.NewFeature(orb.Point{fr.UserGeo.Lng, fr.UserGeo.Lat})
-		f.Properties["cciso2"] = fr.UserGeo.CountryCode
-		f.Properties["countryName"] = fr.UserGeo.CountryName
-		displayName := fr.UserGeo.CityName
-		if fr.UserGeo.StateName != "" {
-			if len(displayName) > 0 && displayName != fr.UserGeo.StateName {
-				if fr.UserGeo.CountryCode == "US" || fr.UserGeo.CountryCode == "CA" || fr.UserGeo.CountryCode == "AU" || fr.UserGeo.CountryCode == "NZ" || fr.UserGeo.CountryCode == "GB" || fr.UserGeo.CountryCode == "CH" {
-					displayName = displayName + ", " + fr.UserGeo.StateCode
+		fmt.Printf("[%s] Resolver reports RFC8805 entry %s/%s maps to %+v\n", key, ip.String(), uniqueloc, gr)
+		f := geojson.NewFeature(orb.Point{gr.Lng, gr.Lat})
+		f.Properties["cciso2"] = gr.CountryCode
+		f.Properties["countryName"] = gr.CountryName
+		// rfc8805Cc is the country the feed's CSV row declared, which can differ
+		// from cciso2 (the resolver's own geolocation of the sample IP); group by
+		// this one when deciding which <CC>.png a location's marker belongs to.
+		f.Properties["rfc8805Cc"] = loc.declaredCC
+		displayName := gr.CityName
+		if gr.StateName != "" {
+			if len(displayName) > 0 && displayName != gr.StateName {
+				if gr.CountryCode == "US" || gr.CountryCode == "CA" || gr.CountryCode == "AU" || gr.CountryCode == "NZ" || gr.CountryCode == "GB" || gr.CountryCode == "CH" {
+					displayName = displayName + ", " + gr.StateCode
 				} else {
-					displayName = displayName + ", " + fr.UserGeo.StateName
+					displayName = displayName + ", " + gr.StateName
 				}
 			} else {
-				displayName = fr.UserGeo.StateName
+				displayName = gr.StateName
 			}
 		}
 		if displayName == "" {
-			displayName = fr.UserGeo.CountryName
+			displayName = gr.CountryName
 		}
 		f.Properties["displayName"] = displayName
-		f.Properties["marker-color"] = colorForBrand(key)
+		if brandColor != "" {
+			f.Properties["marker-color"] = brandColor
+		} else {
+			f.Properties["marker-color"] = colorForBrand(key)
+		}
 		f.Properties["marker-size"] = "large"
 		f.Properties["title"] = providerLabel
 		f.Properties["ip"] = ip.String()
+		// ASN enrichment: prefer the resolver's own ASN database lookup, but
+		// always record the RFC8805 prefix the sample was drawn from (after
+		// per-location merging) as the network identity.
+		if gr.ASN != 0 {
+			f.Properties["asn"] = gr.ASN
+			f.Properties["asnOrg"] = gr.ASNOrg
+		}
+		f.Properties["network"] = loc.prefix.String()
 		fc.Append(f)
 	}
 
@@ -0,0 +1,147 @@
+// Package mapimage renders provider/country hero images as flat PNGs,
+// without depending on a hosted tile service like Mapbox. Country outlines
+// come from the bundled countries.geojson, which covers most ISO 3166-1
+// countries/territories (227 entries; a number of small territories such as
+// Bouvet Island, Christmas Island or Pitcairn are not included); most are a
+// single bounding-box rectangle, with a few
+// archipelagos (e.g. Indonesia, Japan, the Philippines, New Zealand) and
+// elongated landmasses (Chile, Russia) given a coarse multi-point shape
+// instead. None of this is real coastline geometry; swap this file for a
+// full Natural Earth export for production fidelity.
+package mapimage
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+//go:embed countries.geojson
+var countriesGeoJSON []byte
+
+var (
+	countriesOnce sync.Once
+	countries     *geojson.FeatureCollection
+	countriesErr  error
+)
+
+// parsedCountries parses the bundled countries.geojson once and caches it,
+// since Render is called once per feed plus once per visible country.
+func parsedCountries() (*geojson.FeatureCollection, error) {
+	countriesOnce.Do(func() {
+		countries, countriesErr = geojson.UnmarshalFeatureCollection(countriesGeoJSON)
+	})
+	return countries, countriesErr
+}
+
+const (
+	width  = 1024
+	height = 512
+)
+
+// Marker is a single point to overlay on the rendered map, in the same
+// lng/lat + marker-color shape as a GeoJSON feature's properties.
+type Marker struct {
+	Lng   float64
+	Lat   float64
+	Color string
+}
+
+// Render fills the bundled country shapes listed in visibleCountries (ISO2)
+// with fillColor, draws markers on top, and writes the result to outPath as
+// a PNG. Countries not in visibleCountries are not drawn at all (no outline
+// is stroked). A nil/empty visibleCountries fills every country in the
+// bundled dataset.
+func Render(visibleCountries map[string]bool, fillColor string, markers []Marker, outPath string) error {
+	fc, err := parsedCountries()
+	if err != nil {
+		return fmt.Errorf("parsing bundled countries geojson: %w", err)
+	}
+
+	dc := gg.NewContext(width, height)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+
+	fr, fg, fb := parseHexColor(fillColor)
+	for _, f := range fc.Features {
+		cc, _ := f.Properties["iso_a2"].(string)
+		if len(visibleCountries) > 0 && !visibleCountries[cc] {
+			continue
+		}
+		tracePath(dc, f.Geometry)
+		dc.SetRGB255(fr, fg, fb)
+		dc.Fill()
+	}
+
+	for _, m := range markers {
+		x, y := project(m.Lng, m.Lat)
+		r, g, b := parseHexColor(m.Color)
+		dc.SetRGB255(r, g, b)
+		dc.DrawCircle(x, y, 3)
+		dc.Fill()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("creating output dir: %w", err)
+	}
+	return dc.SavePNG(outPath)
+}
+
+// project maps (lng,lat) in degrees onto pixel coordinates via a simple
+// equirectangular projection.
+func project(lng, lat float64) (float64, float64) {
+	x := (lng + 180) / 360 * width
+	y := (90 - lat) / 180 * height
+	return x, y
+}
+
+// tracePath adds geom's rings to dc's current path without filling or
+// stroking it.
+func tracePath(dc *gg.Context, geom orb.Geometry) {
+	switch g := geom.(type) {
+	case orb.Polygon:
+		tracePolygon(dc, g)
+	case orb.MultiPolygon:
+		for _, p := range g {
+			tracePolygon(dc, p)
+		}
+	}
+}
+
+func tracePolygon(dc *gg.Context, poly orb.Polygon) {
+	for _, ring := range poly {
+		for i, pt := range ring {
+			x, y := project(pt[0], pt[1])
+			if i == 0 {
+				dc.MoveTo(x, y)
+			} else {
+				dc.LineTo(x, y)
+			}
+		}
+		dc.ClosePath()
+	}
+}
+
+// parseHexColor parses a "#RRGGBB" string, falling back to Fastah blue on
+// anything it can't parse.
+func parseHexColor(s string) (int, int, int) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return 0x00, 0x9F, 0xE3
+	}
+	r, errR := strconv.ParseInt(s[0:2], 16, 0)
+	g, errG := strconv.ParseInt(s[2:4], 16, 0)
+	b, errB := strconv.ParseInt(s[4:6], 16, 0)
+	if errR != nil || errG != nil || errB != nil {
+		return 0x00, 0x9F, 0xE3
+	}
+	return int(r), int(g), int(b)
+}
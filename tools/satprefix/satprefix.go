@@ -0,0 +1,179 @@
+// Package satprefix indexes RFC8805 geofeed prefixes for fast longest-prefix-match
+// lookup, and can serialize that index to a compact binary artifact so it
+// doesn't need to be rebuilt from CSV on every server start.
+package satprefix
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/netip"
+)
+
+// Entry is what a prefix in the index resolves to: the provider that
+// announced it and the location it was sampled at.
+type Entry struct {
+	Provider string
+	CC       string
+	Region   string
+	City     string
+	Prefix   netip.Prefix
+}
+
+// node is one bit of a binary trie over prefix bits, walked MSB-first.
+// entry is set when a prefix terminates at this node.
+type node struct {
+	children [2]*node
+	entry    *Entry
+}
+
+// Set is a binary trie over netip.Prefix (v4 and v6 share the structure via
+// separate roots) supporting O(addr bit-width) Lookup by longest prefix
+// match - effectively constant time, since that width is fixed at 32 or 128.
+type Set struct {
+	root4 *node
+	root6 *node
+	count int
+}
+
+// NewSet returns an empty Set ready for Insert calls.
+func NewSet() *Set {
+	return &Set{root4: &node{}, root6: &node{}}
+}
+
+// Insert adds e to the index. A later Insert of the same prefix overwrites
+// the earlier entry.
+func (s *Set) Insert(e Entry) {
+	// Unmap so an IPv4-mapped IPv6 prefix (::ffff:1.2.3.0/120) lands in the
+	// same v4 trie as a plain 1.2.3.0/24, instead of silently going to root6.
+	addr := e.Prefix.Addr().Unmap()
+	n := s.rootFor(addr)
+	bits := e.Prefix.Bits()
+	if addr != e.Prefix.Addr() {
+		bits -= 96 // an unmapped v4-in-v6 /120 prefix is a v4 /24
+	}
+	for i := 0; i < bits; i++ {
+		bit := bitAt(addr, i)
+		if n.children[bit] == nil {
+			n.children[bit] = &node{}
+		}
+		n = n.children[bit]
+	}
+	ec := e
+	if n.entry == nil {
+		s.count++
+	}
+	n.entry = &ec
+}
+
+// Len returns the number of prefixes in the index.
+func (s *Set) Len() int {
+	return s.count
+}
+
+// Lookup returns the entry for the most specific (longest) prefix
+// containing addr, if any. addr is unmapped first, so an IPv4-mapped IPv6
+// address (e.g. parsed from a raw X-Forwarded-For value) matches prefixes
+// that were inserted in plain v4 form.
+func (s *Set) Lookup(addr netip.Addr) (Entry, bool) {
+	addr = addr.Unmap()
+	n := s.rootFor(addr)
+	var last *Entry
+	if n.entry != nil {
+		last = n.entry
+	}
+	for i := 0; i < addr.BitLen() && n != nil; i++ {
+		n = n.children[bitAt(addr, i)]
+		if n == nil {
+			break
+		}
+		if n.entry != nil {
+			last = n.entry
+		}
+	}
+	if last == nil {
+		return Entry{}, false
+	}
+	return *last, true
+}
+
+// Entries returns every entry in the index, in no particular order.
+func (s *Set) Entries() []Entry {
+	out := make([]Entry, 0, s.count)
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n == nil {
+			return
+		}
+		if n.entry != nil {
+			out = append(out, *n.entry)
+		}
+		walk(n.children[0])
+		walk(n.children[1])
+	}
+	walk(s.root4)
+	walk(s.root6)
+	return out
+}
+
+func (s *Set) rootFor(addr netip.Addr) *node {
+	if addr.BitLen() == 32 {
+		return s.root4
+	}
+	return s.root6
+}
+
+// bitAt returns the i-th bit (0 = MSB) of addr, which must be a v4 or v6
+// (not v4-in-v6) address.
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-uint(i%8))) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-uint(i%8))) & 1
+}
+
+// entryDTO is the on-the-wire shape of an Entry: netip.Prefix round-trips
+// more robustly through its string form than through gob's reflection-based
+// encoding of its unexported fields.
+type entryDTO struct {
+	Provider string
+	CC       string
+	Region   string
+	City     string
+	Prefix   string
+}
+
+// MarshalBinary serializes the index so it can be shipped as a standalone
+// artifact (e.g. gen/latest-feeds/<key>/index.bin) and loaded later without
+// re-parsing the source CSV.
+func (s *Set) MarshalBinary() ([]byte, error) {
+	entries := s.Entries()
+	dtos := make([]entryDTO, len(entries))
+	for i, e := range entries {
+		dtos[i] = entryDTO{Provider: e.Provider, CC: e.CC, Region: e.Region, City: e.City, Prefix: e.Prefix.String()}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(dtos); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces s's contents with the index encoded in data.
+func (s *Set) UnmarshalBinary(data []byte) error {
+	var dtos []entryDTO
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&dtos); err != nil {
+		return err
+	}
+	fresh := NewSet()
+	for _, d := range dtos {
+		prefix, err := netip.ParsePrefix(d.Prefix)
+		if err != nil {
+			continue
+		}
+		fresh.Insert(Entry{Provider: d.Provider, CC: d.CC, Region: d.Region, City: d.City, Prefix: prefix})
+	}
+	*s = *fresh
+	return nil
+}
@@ -0,0 +1,112 @@
+package satprefix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func mustPrefix(t *testing.T, s string) netip.Prefix {
+	t.Helper()
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		t.Fatalf("ParsePrefix(%q): %v", s, err)
+	}
+	return p
+}
+
+func mustAddr(t *testing.T, s string) netip.Addr {
+	t.Helper()
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		t.Fatalf("ParseAddr(%q): %v", s, err)
+	}
+	return a
+}
+
+func TestLookupLongestPrefixMatch(t *testing.T) {
+	s := NewSet()
+	s.Insert(Entry{Provider: "starlink", CC: "US", Prefix: mustPrefix(t, "1.2.0.0/16")})
+	s.Insert(Entry{Provider: "starlink", CC: "CA", Prefix: mustPrefix(t, "1.2.3.0/24")})
+
+	e, ok := s.Lookup(mustAddr(t, "1.2.3.4"))
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if e.CC != "CA" {
+		t.Errorf("expected the more specific /24 entry (CC=CA) to win, got CC=%s", e.CC)
+	}
+
+	e, ok = s.Lookup(mustAddr(t, "1.2.9.9"))
+	if !ok || e.CC != "US" {
+		t.Errorf("expected the /16 entry (CC=US) outside the /24, got %+v, ok=%v", e, ok)
+	}
+
+	if _, ok := s.Lookup(mustAddr(t, "8.8.8.8")); ok {
+		t.Error("expected no match for an IP outside any inserted prefix")
+	}
+}
+
+func TestLookupIPv6(t *testing.T) {
+	s := NewSet()
+	s.Insert(Entry{Provider: "viasat", CC: "DE", Prefix: mustPrefix(t, "2001:db8::/32")})
+
+	e, ok := s.Lookup(mustAddr(t, "2001:db8::1"))
+	if !ok || e.CC != "DE" {
+		t.Fatalf("expected a v6 match with CC=DE, got %+v, ok=%v", e, ok)
+	}
+
+	if _, ok := s.Lookup(mustAddr(t, "2001:db9::1")); ok {
+		t.Error("expected no match for an address outside the v6 prefix")
+	}
+
+	// The v4 and v6 tries must not cross-match.
+	if _, ok := s.Lookup(mustAddr(t, "1.2.3.4")); ok {
+		t.Error("expected no v4 match when only a v6 prefix is inserted")
+	}
+}
+
+func TestLookupUnmapsIPv4MappedIPv6(t *testing.T) {
+	s := NewSet()
+	s.Insert(Entry{Provider: "starlink", CC: "US", Prefix: mustPrefix(t, "1.2.3.0/24")})
+
+	// netip.ParseAddr does NOT auto-unmap "::ffff:1.2.3.4"-style addresses,
+	// which is exactly what a trusted X-Forwarded-For value can look like.
+	mapped := mustAddr(t, "::ffff:1.2.3.4")
+	if mapped.Is4() {
+		t.Fatalf("test precondition failed: %s unexpectedly parsed as v4", mapped)
+	}
+
+	e, ok := s.Lookup(mapped)
+	if !ok || e.CC != "US" {
+		t.Errorf("expected the v4-mapped address to match the plain v4 prefix, got %+v, ok=%v", e, ok)
+	}
+}
+
+func TestMarshalBinaryRoundTrip(t *testing.T) {
+	s := NewSet()
+	s.Insert(Entry{Provider: "starlink", CC: "US", Region: "CA", City: "Hawthorne", Prefix: mustPrefix(t, "1.2.3.0/24")})
+	s.Insert(Entry{Provider: "starlink", CC: "DE", Region: "BY", City: "Munich", Prefix: mustPrefix(t, "2001:db8::/32")})
+
+	blob, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	restored := NewSet()
+	if err := restored.UnmarshalBinary(blob); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	if restored.Len() != s.Len() {
+		t.Fatalf("expected %d entries after round-trip, got %d", s.Len(), restored.Len())
+	}
+
+	e, ok := restored.Lookup(mustAddr(t, "1.2.3.4"))
+	if !ok || e.City != "Hawthorne" {
+		t.Errorf("expected restored v4 entry for Hawthorne, got %+v, ok=%v", e, ok)
+	}
+	e, ok = restored.Lookup(mustAddr(t, "2001:db8::1"))
+	if !ok || e.City != "Munich" {
+		t.Errorf("expected restored v6 entry for Munich, got %+v, ok=%v", e, ok)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fastah/space/tools/satprefix"
+)
+
+// providerIndex is the in-memory lookup structure for a single feed,
+// rebuilt from that feed's generated gen/latest-feeds/<key>/ artifacts.
+type providerIndex struct {
+	key          string
+	displayName  string
+	lastModified time.Time
+	geojsonPath  string
+	prefixes     *satprefix.Set
+}
+
+// lookupResult is the shape returned by GET /v1/lookup/{ip}.
+type lookupResult struct {
+	IsSatellite bool   `json:"isSatellite"`
+	Provider    string `json:"provider,omitempty"`
+	CC          string `json:"cc,omitempty"`
+	Region      string `json:"region,omitempty"`
+	City        string `json:"city,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+}
+
+// lookup does a longest-prefix match of ip against this provider's compiled index.
+func (p *providerIndex) lookup(ip netip.Addr) (satprefix.Entry, bool) {
+	return p.prefixes.Lookup(ip)
+}
+
+// loadProviderIndex rebuilds a providerIndex from a feed's already-generated
+// index.bin and rfc8805.meta.json, without re-parsing the source CSV.
+func loadProviderIndex(feed GeoFeed, dirpath string) (*providerIndex, error) {
+	indexBlob, err := os.ReadFile(filepath.Join(dirpath, "index.bin"))
+	if err != nil {
+		return nil, fmt.Errorf("reading compiled prefix index: %w", err)
+	}
+	prefixes := satprefix.NewSet()
+	if err := prefixes.UnmarshalBinary(indexBlob); err != nil {
+		return nil, fmt.Errorf("decoding compiled prefix index: %w", err)
+	}
+
+	var meta struct {
+		LastModified string `json:"lastModified"`
+	}
+	if metaBlob, err := os.ReadFile(filepath.Join(dirpath, "rfc8805.meta.json")); err == nil {
+		_ = json.Unmarshal(metaBlob, &meta)
+	}
+	lastModified, _ := time.Parse(time.RFC3339, meta.LastModified)
+
+	return &providerIndex{
+		key:          feed.key,
+		displayName:  feed.providerName,
+		lastModified: lastModified,
+		geojsonPath:  filepath.Join(dirpath, "samples.json"),
+		prefixes:     prefixes,
+	}, nil
+}
+
+// lruCache is a small fixed-capacity LRU cache for /v1/lookup responses,
+// keyed by the normalized IP string.
+type lruCache struct {
+	mu    sync.Mutex
+	cap   int
+	order []string
+	data  map[string]lookupResult
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{cap: capacity, data: make(map[string]lookupResult)}
+}
+
+func (c *lruCache) get(key string) (lookupResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.data[key]
+	if ok {
+		c.touch(key)
+	}
+	return v, ok
+}
+
+func (c *lruCache) put(key string, v lookupResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.data[key]; !exists {
+		if len(c.order) >= c.cap && c.cap > 0 {
+			oldest := c.order[0]
+			c.order = c.order[1:]
+			delete(c.data, oldest)
+		}
+		c.order = append(c.order, key)
+	} else {
+		c.touch(key)
+	}
+	c.data[key] = v
+}
+
+// touch moves key to the back of c.order, marking it most-recently-used.
+// Callers must hold c.mu.
+func (c *lruCache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, key)
+}
+
+// server holds the loaded provider indexes backing the serve subcommand.
+type server struct {
+	providers map[string]*providerIndex
+	cache     *lruCache
+}
+
+// clientIP prefers X-Forwarded-For / X-Real-IP (for use behind a trusted
+// proxy) and falls back to the request's own remote address.
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
+		return strings.TrimSpace(xrip)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (s *server) handleProviders(w http.ResponseWriter, r *http.Request) {
+	type providerMeta struct {
+		Key          string `json:"key"`
+		Name         string `json:"name"`
+		LastModified string `json:"lastModified"`
+	}
+	list := make([]providerMeta, 0, len(s.providers))
+	for key, idx := range s.providers {
+		list = append(list, providerMeta{Key: key, Name: idx.displayName, LastModified: idx.lastModified.Format(time.RFC3339)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *server) handleProviderGeoJSON(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v1/providers/")
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 || parts[1] != "geojson" {
+		http.NotFound(w, r)
+		return
+	}
+	idx, ok := s.providers[parts[0]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, idx.geojsonPath)
+}
+
+func (s *server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	ipStr := strings.TrimPrefix(r.URL.Path, "/v1/lookup/")
+	if ipStr == "" {
+		ipStr = clientIP(r)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid IP %q: %s", ipStr, err), http.StatusBadRequest)
+		return
+	}
+
+	if cached, ok := s.cache.get(ip.String()); ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cached)
+		return
+	}
+
+	// Providers can have overlapping prefixes (e.g. Starlink and Viasat both
+	// reassign prefixes across countries), so iterate in a stable (sorted)
+	// order and let the most specific (longest) matched prefix win, rather
+	// than whichever provider's map iteration happens to run first.
+	providerKeys := make([]string, 0, len(s.providers))
+	for key := range s.providers {
+		providerKeys = append(providerKeys, key)
+	}
+	sort.Strings(providerKeys)
+
+	var result lookupResult
+	bestBits := -1
+	for _, key := range providerKeys {
+		e, ok := s.providers[key].lookup(ip)
+		if !ok || e.Prefix.Bits() <= bestBits {
+			continue
+		}
+		bestBits = e.Prefix.Bits()
+		result = lookupResult{
+			IsSatellite: true,
+			Provider:    key,
+			CC:          e.CC,
+			Region:      e.Region,
+			City:        e.City,
+			Prefix:      e.Prefix.String(),
+		}
+	}
+	s.cache.put(ip.String(), result)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// runServe implements the `serve` subcommand: an HTTP service backed by the
+// feeds' already-generated gen/latest-feeds/<key>/ artifacts.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	feedsPath := fs.String("feeds", "feeds.yaml", "path to the feed registry config file")
+	onlyFlag := fs.String("only", "", "comma-separated list of feed keys to serve (default: all feeds in the config)")
+	cacheSize := fs.Int("cache-size", 4096, "number of /v1/lookup results to cache")
+	fs.Parse(args)
+
+	var only []string
+	if *onlyFlag != "" {
+		only = strings.Split(*onlyFlag, ",")
+	}
+	feeds, err := loadFeeds(*feedsPath, only)
+	if err != nil {
+		fmt.Printf("Error loading feeds config: %s\n", err)
+		os.Exit(1)
+	}
+
+	srv := &server{providers: make(map[string]*providerIndex), cache: newLRUCache(*cacheSize)}
+	for _, feed := range feeds {
+		dirpath := filepath.Join("..", "gen", "latest-feeds", strings.ToLower(feed.key))
+		idx, err := loadProviderIndex(feed, dirpath)
+		if err != nil {
+			fmt.Printf("[%s] Error loading provider index, skipping: %s\n", feed.key, err)
+			continue
+		}
+		srv.providers[feed.key] = idx
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", srv.handleHealthz)
+	mux.HandleFunc("/v1/providers", srv.handleProviders)
+	mux.HandleFunc("/v1/providers/", srv.handleProviderGeoJSON)
+	mux.HandleFunc("/v1/lookup/", srv.handleLookup)
+
+	fmt.Printf("Serving %d provider(s) on %s\n", len(srv.providers), *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fmt.Printf("Error serving: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(2)
+	c.put("a", lookupResult{Provider: "a"})
+	c.put("b", lookupResult{Provider: "b"})
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	c.put("c", lookupResult{Provider: "c"})
+
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to be evicted as the least recently used entry")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("expected a to survive eviction, since it was touched most recently")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("expected c to be cached as the most recent insert")
+	}
+}
+
+func TestLRUCacheOverwriteDoesNotEvict(t *testing.T) {
+	c := newLRUCache(2)
+	c.put("a", lookupResult{Provider: "a"})
+	c.put("b", lookupResult{Provider: "b"})
+	c.put("a", lookupResult{Provider: "a-updated"})
+
+	v, ok := c.get("a")
+	if !ok || v.Provider != "a-updated" {
+		t.Errorf("expected updated value for a, got %+v, ok=%v", v, ok)
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Error("expected b to still be cached, since re-putting a shouldn't evict anything")
+	}
+}
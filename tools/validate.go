@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// ValidationIssue records why a single RFC8805 CSV row was rejected.
+type ValidationIssue struct {
+	Row    int    `json:"row"`
+	Column string `json:"column"`
+	Reason string `json:"reason"`
+}
+
+// ValidationReport is written to gen/latest-feeds/<key>/validation.json so
+// rejected rows aren't just silently dropped from the generated output.
+type ValidationReport struct {
+	Key       string            `json:"key"`
+	TotalRows int               `json:"totalRows"`
+	Issues    []ValidationIssue `json:"issues"`
+}
+
+// validRow is a single RFC8805 row that passed validation.
+type validRow struct {
+	prefix      netip.Prefix
+	locationKey string
+	cc          string
+}
+
+// validateRows checks each RFC8805 CSV row for a parseable, non-private
+// prefix and a usable (cc, region, city) location key, returning the rows
+// that pass alongside a report of anything that didn't.
+func validateRows(key string, rows [][]string) ([]validRow, ValidationReport) {
+	report := ValidationReport{Key: key, TotalRows: len(rows)}
+	var valid []validRow
+	for i, row := range rows {
+		if len(row) < 4 {
+			report.Issues = append(report.Issues, ValidationIssue{Row: i, Column: "-", Reason: fmt.Sprintf("expected at least 4 columns, got %d", len(row))})
+			continue
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(row[0]))
+		if err != nil {
+			report.Issues = append(report.Issues, ValidationIssue{Row: i, Column: "prefix", Reason: err.Error()})
+			continue
+		}
+		if !prefix.IsValid() || prefix.Addr().IsPrivate() {
+			report.Issues = append(report.Issues, ValidationIssue{Row: i, Column: "prefix", Reason: "private or invalid prefix"})
+			continue
+		}
+		locationKey := feedColumnsToKey(row)
+		if locationKey == "" {
+			report.Issues = append(report.Issues, ValidationIssue{Row: i, Column: "cciso2/region/city", Reason: "could not derive a location key"})
+			continue
+		}
+		valid = append(valid, validRow{
+			prefix:      prefix,
+			locationKey: locationKey,
+			cc:          strings.ToUpper(strings.TrimSpace(row[1])),
+		})
+	}
+	return valid, report
+}
+
+// aggregateByLocation merges adjacent/overlapping prefixes that share a
+// (cc, region, city) location key into their minimal covering set, so the
+// same ground station announced as several contiguous /24s collapses to one
+// entry instead of several near-duplicate samples.
+func aggregateByLocation(rows []validRow) (merged map[string][]netip.Prefix, ccByLocation map[string]string) {
+	builders := make(map[string]*netipx.IPSetBuilder)
+	ccByLocation = make(map[string]string)
+	for _, r := range rows {
+		b, ok := builders[r.locationKey]
+		if !ok {
+			b = &netipx.IPSetBuilder{}
+			builders[r.locationKey] = b
+		}
+		b.AddPrefix(r.prefix)
+		ccByLocation[r.locationKey] = r.cc
+	}
+	merged = make(map[string][]netip.Prefix, len(builders))
+	for loc, b := range builders {
+		set, err := b.IPSet()
+		if err != nil {
+			fmt.Printf("Error building merged prefix set for location %s: %s\n", loc, err)
+			continue
+		}
+		merged[loc] = set.Prefixes()
+	}
+	return merged, ccByLocation
+}
+
+// DiffEntry describes one prefix's change between two runs of the generator.
+type DiffEntry struct {
+	Prefix       string `json:"prefix"`
+	Location     string `json:"location,omitempty"`
+	FromLocation string `json:"fromLocation,omitempty"`
+	ToLocation   string `json:"toLocation,omitempty"`
+}
+
+// DiffReport is written to gen/latest-feeds/<key>/diff.json, giving a
+// changelog of ground-station rollouts between successive generator runs.
+type DiffReport struct {
+	Key     string      `json:"key"`
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+	Moved   []DiffEntry `json:"moved"`
+}
+
+// diffSnapshots compares the previous run's prefix->location snapshot
+// against the current one.
+func diffSnapshots(key string, previous, current map[string]string) DiffReport {
+	report := DiffReport{Key: key}
+	for prefix, loc := range current {
+		prevLoc, existed := previous[prefix]
+		switch {
+		case !existed:
+			report.Added = append(report.Added, DiffEntry{Prefix: prefix, Location: loc})
+		case prevLoc != loc:
+			report.Moved = append(report.Moved, DiffEntry{Prefix: prefix, FromLocation: prevLoc, ToLocation: loc})
+		}
+	}
+	for prefix, loc := range previous {
+		if _, stillPresent := current[prefix]; !stillPresent {
+			report.Removed = append(report.Removed, DiffEntry{Prefix: prefix, Location: loc})
+		}
+	}
+	return report
+}
+
+// loadSnapshot reads a previous run's prefix->location snapshot. A missing
+// file (the common case for a feed's first run) is not an error.
+func loadSnapshot(path string) (map[string]string, error) {
+	blob, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]string)
+	if err := json.Unmarshal(blob, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// writeJSONFile marshals v as indented JSON and writes it to path.
+func writeJSONFile(path string, v any) error {
+	blob, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, blob, 0644)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestValidateRowsRejectsMalformedRows(t *testing.T) {
+	rows := [][]string{
+		{"1.2.3.0/24", "US", "CA", "Hawthorne"},   // valid
+		{"not-a-prefix", "US", "CA", "Hawthorne"}, // bad prefix
+		{"10.0.0.0/8", "US", "CA", "Hawthorne"},   // private, rejected
+		{"1.2.3.0/24"},                            // too few columns
+		{"2.3.4.0/24", "ZZZ", "CA", "Hawthorne"},  // cc too long, no location key
+	}
+
+	valid, report := validateRows("testfeed", rows)
+
+	if len(valid) != 1 {
+		t.Fatalf("expected 1 valid row, got %d: %+v", len(valid), valid)
+	}
+	if valid[0].cc != "US" || valid[0].locationKey != "US,CA,Hawthorne" {
+		t.Errorf("unexpected valid row: %+v", valid[0])
+	}
+	if report.TotalRows != len(rows) {
+		t.Errorf("expected TotalRows=%d, got %d", len(rows), report.TotalRows)
+	}
+	if len(report.Issues) != len(rows)-1 {
+		t.Errorf("expected %d issues, got %d: %+v", len(rows)-1, len(report.Issues), report.Issues)
+	}
+}
+
+func TestAggregateByLocationMergesAdjacentPrefixes(t *testing.T) {
+	rows := []validRow{
+		{prefix: netip.MustParsePrefix("1.2.0.0/24"), locationKey: "US,CA,Hawthorne", cc: "US"},
+		{prefix: netip.MustParsePrefix("1.2.1.0/24"), locationKey: "US,CA,Hawthorne", cc: "US"},
+		{prefix: netip.MustParsePrefix("9.9.9.0/24"), locationKey: "DE,BY,Munich", cc: "DE"},
+	}
+
+	merged, ccByLocation := aggregateByLocation(rows)
+
+	hawthorne := merged["US,CA,Hawthorne"]
+	if len(hawthorne) != 1 || hawthorne[0].String() != "1.2.0.0/23" {
+		t.Errorf("expected the two adjacent /24s to merge into 1.2.0.0/23, got %v", hawthorne)
+	}
+	if ccByLocation["US,CA,Hawthorne"] != "US" {
+		t.Errorf("expected cc US for Hawthorne, got %s", ccByLocation["US,CA,Hawthorne"])
+	}
+
+	munich := merged["DE,BY,Munich"]
+	if len(munich) != 1 || munich[0].String() != "9.9.9.0/24" {
+		t.Errorf("expected the unrelated /24 to pass through unmerged, got %v", munich)
+	}
+}
+
+func TestDiffSnapshotsClassifiesAddedRemovedMoved(t *testing.T) {
+	previous := map[string]string{
+		"1.2.3.0/24": "US,CA,Hawthorne",
+		"5.6.7.0/24": "DE,BY,Munich",
+		"8.8.8.0/24": "US,WA,Seattle",
+	}
+	current := map[string]string{
+		"1.2.3.0/24": "US,CA,Hawthorne", // unchanged
+		"5.6.7.0/24": "FR,IDF,Paris",    // moved
+		"9.9.9.0/24": "US,NY,NewYork",   // added
+		// 8.8.8.0/24 removed
+	}
+
+	diff := diffSnapshots("testfeed", previous, current)
+
+	if len(diff.Added) != 1 || diff.Added[0].Prefix != "9.9.9.0/24" {
+		t.Errorf("expected one added entry for 9.9.9.0/24, got %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Prefix != "8.8.8.0/24" {
+		t.Errorf("expected one removed entry for 8.8.8.0/24, got %+v", diff.Removed)
+	}
+	if len(diff.Moved) != 1 || diff.Moved[0].Prefix != "5.6.7.0/24" ||
+		diff.Moved[0].FromLocation != "DE,BY,Munich" || diff.Moved[0].ToLocation != "FR,IDF,Paris" {
+		t.Errorf("expected one moved entry for 5.6.7.0/24 DE,BY,Munich -> FR,IDF,Paris, got %+v", diff.Moved)
+	}
+}